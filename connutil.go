@@ -0,0 +1,20 @@
+package main
+
+import (
+	"bufio"
+	"net"
+)
+
+// bufferedConn adapts a net.Conn whose initial bytes have already been
+// buffered into a bufio.Reader (e.g. because the request line was parsed
+// off it) back into a plain net.Conn, so that code expecting to read raw
+// bytes - splicing, tls.Server - doesn't lose whatever was already
+// buffered.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (b *bufferedConn) Read(p []byte) (int, error) {
+	return b.r.Read(p)
+}
@@ -0,0 +1,29 @@
+package main
+
+import "io"
+
+// countingReader wraps an io.Reader, tallying bytes read so that relaying
+// code built around io.Copy can still report how much data passed
+// through.
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// countingWriter is the write-side counterpart of countingReader.
+type countingWriter struct {
+	io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.Writer.Write(p)
+	c.n += int64(n)
+	return n, err
+}
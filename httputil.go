@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// hopByHopHeaders lists the headers that RFC 7230 section 6.1 says must
+// not be forwarded past a single hop.
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"TE",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+	"Proxy-Authorization",
+}
+
+// stripHopByHopHeaders deletes the standard hop-by-hop headers from h, as
+// well as any additional header named in a Connection token (per RFC 7230
+// section 6.1, a proxy must honour such per-request hop-by-hop headers
+// too).
+func stripHopByHopHeaders(h http.Header) {
+	for _, field := range strings.Split(h.Get("Connection"), ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			h.Del(field)
+		}
+	}
+	for _, name := range hopByHopHeaders {
+		h.Del(name)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+)
+
+// hostMatches reports whether host matches pattern. A pattern that parses
+// as a CIDR ("10.0.0.0/8") matches when host is an IP address contained
+// in it; otherwise pattern is matched as a shell glob ("*.example.com")
+// against the hostname.
+func hostMatches(pattern, host string) bool {
+	if _, ipNet, err := net.ParseCIDR(pattern); err == nil {
+		ip := net.ParseIP(host)
+		return ip != nil && ipNet.Contains(ip)
+	}
+	matched, err := filepath.Match(pattern, host)
+	return err == nil && matched
+}
+
+// hostMatchesAny reports whether host matches any of patterns.
+func hostMatchesAny(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if hostMatches(pattern, host) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package main
+
+import "testing"
+
+func TestHostMatches(t *testing.T) {
+	tests := []struct {
+		pattern string
+		host    string
+		want    bool
+	}{
+		{"*.example.com", "www.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"example.com", "example.com", true},
+		{"10.0.0.0/8", "10.1.2.3", true},
+		{"10.0.0.0/8", "192.168.1.1", false},
+		{"192.168.1.1/32", "192.168.1.1", true},
+		{"*.internal", "host.other", false},
+	}
+
+	for _, tt := range tests {
+		if got := hostMatches(tt.pattern, tt.host); got != tt.want {
+			t.Errorf("hostMatches(%q, %q) = %v, want %v", tt.pattern, tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestHostMatchesAny(t *testing.T) {
+	patterns := []string{"*.example.com", "10.0.0.0/8"}
+
+	if !hostMatchesAny(patterns, "api.example.com") {
+		t.Error("expected api.example.com to match *.example.com")
+	}
+	if !hostMatchesAny(patterns, "10.2.3.4") {
+		t.Error("expected 10.2.3.4 to match 10.0.0.0/8")
+	}
+	if hostMatchesAny(patterns, "example.org") {
+		t.Error("expected example.org to match nothing")
+	}
+	if hostMatchesAny(nil, "example.org") {
+		t.Error("expected an empty pattern list to match nothing")
+	}
+}
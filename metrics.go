@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// metricsRegistry holds a Prometheus-style requests counter and upstream
+// latency histogram, rendered at /metrics in the text exposition format.
+// It's hand-rolled rather than built on the Prometheus client library so
+// the proxy keeps no external dependencies.
+type metricsRegistry struct {
+	mu            sync.Mutex
+	requestsTotal map[string]int64 // "method|status" -> count
+	buckets       []float64
+	bucketCounts  []int64
+	sum           float64
+	count         int64
+}
+
+// metrics is the process-wide registry; recordMetrics feeds it from
+// accessLog and the /metrics handler renders it on demand.
+var metrics = newMetricsRegistry()
+
+func newMetricsRegistry() *metricsRegistry {
+	buckets := []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	return &metricsRegistry{
+		requestsTotal: make(map[string]int64),
+		buckets:       buckets,
+		bucketCounts:  make([]int64, len(buckets)),
+	}
+}
+
+func recordMetrics(rec RequestRecord) {
+	metrics.observe(rec.Method, rec.Status, rec.UpstreamLatency.Seconds())
+}
+
+func (m *metricsRegistry) observe(method string, status int, latencySeconds float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requestsTotal[method+"|"+strconv.Itoa(status)]++
+
+	m.sum += latencySeconds
+	m.count++
+	for i, bound := range m.buckets {
+		if latencySeconds <= bound {
+			m.bucketCounts[i]++
+		}
+	}
+}
+
+// ServeHTTP renders the registry in the Prometheus text exposition
+// format so it can be scraped directly.
+func (m *metricsRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+
+	b.WriteString("# HELP proxy_requests_total Total number of proxied requests.\n")
+	b.WriteString("# TYPE proxy_requests_total counter\n")
+	keys := make([]string, 0, len(m.requestsTotal))
+	for k := range m.requestsTotal {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		method, status, _ := strings.Cut(k, "|")
+		fmt.Fprintf(&b, "proxy_requests_total{method=%q,status=%q} %d\n", method, status, m.requestsTotal[k])
+	}
+
+	b.WriteString("# HELP proxy_upstream_latency_seconds Upstream request latency.\n")
+	b.WriteString("# TYPE proxy_upstream_latency_seconds histogram\n")
+	for i, bound := range m.buckets {
+		fmt.Fprintf(&b, "proxy_upstream_latency_seconds_bucket{le=%q} %d\n", strconv.FormatFloat(bound, 'g', -1, 64), m.bucketCounts[i])
+	}
+	fmt.Fprintf(&b, "proxy_upstream_latency_seconds_bucket{le=\"+Inf\"} %d\n", m.count)
+	fmt.Fprintf(&b, "proxy_upstream_latency_seconds_sum %g\n", m.sum)
+	fmt.Fprintf(&b, "proxy_upstream_latency_seconds_count %d\n", m.count)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(b.String()))
+}
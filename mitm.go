@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bufio"
+	"container/list"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MitmConfig holds everything needed to terminate TLS on behalf of the
+// client instead of tunneling it blindly. When Enabled is false,
+// handleHTTPS falls back to plain byte-for-byte tunneling.
+type MitmConfig struct {
+	Enabled bool
+
+	// InsecureSkipVerify disables certificate verification when dialing
+	// the upstream origin over TLS. Off by default.
+	InsecureSkipVerify bool
+
+	// PinnedCertSHA256 optionally maps a host to the hex-encoded SHA-256
+	// digest of the DER leaf certificate it is expected to present; the
+	// upstream TLS connection is rejected if the digest doesn't match.
+	// Hosts absent from the map aren't pinned.
+	PinnedCertSHA256 map[string]string
+
+	caCert *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+
+	certs *leafCertCache
+}
+
+// NewMitmConfig loads a CA certificate/key pair in PEM form from disk and
+// prepares an empty leaf certificate cache. The CA is expected to have
+// the basic-constraints CA bit set so that minted leaves chain to it.
+func NewMitmConfig(caCertPath, caKeyPath string, cacheSize int) (*MitmConfig, error) {
+	certPEM, err := os.ReadFile(caCertPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading CA cert: %w", err)
+	}
+	keyPEM, err := os.ReadFile(caKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading CA key: %w", err)
+	}
+
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, fmt.Errorf("no PEM block found in %s", caCertPath)
+	}
+	caCert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing CA cert: %w", err)
+	}
+
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, fmt.Errorf("no PEM block found in %s", caKeyPath)
+	}
+	caKey, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing CA key: %w", err)
+	}
+
+	return &MitmConfig{
+		Enabled: true,
+		caCert:  caCert,
+		caKey:   caKey,
+		certs:   newLeafCertCache(cacheSize),
+	}, nil
+}
+
+// parseMitmPins parses a comma-separated "host=sha256,..." list (as
+// accepted by the -mitm-pin flag) into the map form PinnedCertSHA256
+// expects, hex digests lowercased to match checkCertPin's comparison.
+func parseMitmPins(raw string) (map[string]string, error) {
+	pins := make(map[string]string)
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		host, sha, ok := strings.Cut(pair, "=")
+		if !ok || host == "" || sha == "" {
+			return nil, fmt.Errorf("malformed pin entry %q, want host=sha256", pair)
+		}
+		pins[host] = strings.ToLower(sha)
+	}
+	return pins, nil
+}
+
+// leafCertCache is a small LRU cache of minted leaf certificates keyed by
+// SNI hostname, guarded by a singleflight so that concurrent CONNECTs for
+// the same host only generate one certificate.
+type leafCertCache struct {
+	mu       sync.Mutex
+	maxSize  int
+	ll       *list.List
+	entries  map[string]*list.Element
+	inflight map[string]*certCall
+}
+
+type certCall struct {
+	done chan struct{}
+	cert *tls.Certificate
+	err  error
+}
+
+type cacheEntry struct {
+	host string
+	cert *tls.Certificate
+}
+
+func newLeafCertCache(maxSize int) *leafCertCache {
+	if maxSize <= 0 {
+		maxSize = 1024
+	}
+	return &leafCertCache{
+		maxSize:  maxSize,
+		ll:       list.New(),
+		entries:  make(map[string]*list.Element),
+		inflight: make(map[string]*certCall),
+	}
+}
+
+func (c *leafCertCache) get(host string) (*tls.Certificate, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[host]; ok {
+		c.ll.MoveToFront(el)
+		return el.Value.(*cacheEntry).cert, true
+	}
+	return nil, false
+}
+
+func (c *leafCertCache) add(host string, cert *tls.Certificate) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[host]; ok {
+		c.ll.MoveToFront(el)
+		el.Value.(*cacheEntry).cert = cert
+		return
+	}
+	el := c.ll.PushFront(&cacheEntry{host: host, cert: cert})
+	c.entries[host] = el
+	if c.ll.Len() > c.maxSize {
+		oldest := c.ll.Back()
+		if oldest != nil {
+			c.ll.Remove(oldest)
+			delete(c.entries, oldest.Value.(*cacheEntry).host)
+		}
+	}
+}
+
+// leafFor returns a leaf certificate for host, minting and caching one on
+// first use. Concurrent callers for the same host share a single mint.
+func (m *MitmConfig) leafFor(host string) (*tls.Certificate, error) {
+	if cert, ok := m.certs.get(host); ok {
+		return cert, nil
+	}
+
+	m.certs.mu.Lock()
+	if call, ok := m.certs.inflight[host]; ok {
+		m.certs.mu.Unlock()
+		<-call.done
+		return call.cert, call.err
+	}
+	call := &certCall{done: make(chan struct{})}
+	m.certs.inflight[host] = call
+	m.certs.mu.Unlock()
+
+	cert, err := m.mintLeaf(host)
+	call.cert, call.err = cert, err
+	close(call.done)
+
+	m.certs.mu.Lock()
+	delete(m.certs.inflight, host)
+	m.certs.mu.Unlock()
+
+	if err == nil {
+		m.certs.add(host, cert)
+	}
+	return cert, err
+}
+
+// mintLeaf generates a fresh leaf certificate for host, signed by the
+// configured CA, valid for SANs matching the requested host.
+func (m *MitmConfig) mintLeaf(host string) (*tls.Certificate, error) {
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generating leaf key: %w", err)
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("generating serial: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, m.caCert, &leafKey.PublicKey, m.caKey)
+	if err != nil {
+		return nil, fmt.Errorf("signing leaf cert: %w", err)
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der, m.caCert.Raw},
+		PrivateKey:  leafKey,
+	}, nil
+}
+
+// handleHTTPSMitm terminates TLS from the client using a freshly minted
+// leaf certificate for host, dials upstream over TLS, and relays parsed
+// HTTP requests/responses between the two so that callers can log or
+// rewrite them instead of just shuffling bytes.
+func handleHTTPSMitm(mitm *MitmConfig, clientConn net.Conn, host string) {
+	clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+
+	hostname := hostOnly(host)
+
+	leaf, err := mitm.leafFor(hostname)
+	if err != nil {
+		logMessage(ERROR, "mitm: failed to mint leaf cert for %s: %v\n", host, err)
+		return
+	}
+
+	tlsClientConn := tls.Server(clientConn, &tls.Config{
+		Certificates: []tls.Certificate{*leaf},
+	})
+	if err := tlsClientConn.Handshake(); err != nil {
+		logMessage(ERROR, "mitm: client TLS handshake with %s failed: %v\n", host, err)
+		return
+	}
+	defer tlsClientConn.Close()
+
+	rawServerConn, err := upstreams.Dial(hostname, host)
+	if err != nil {
+		logMessage(ERROR, "mitm: unable to dial upstream %s: %v\n", host, err)
+		return
+	}
+	serverConn := tls.Client(rawServerConn, &tls.Config{
+		InsecureSkipVerify: mitm.InsecureSkipVerify,
+		ServerName:         hostname,
+	})
+	defer serverConn.Close()
+	if err := serverConn.Handshake(); err != nil {
+		logMessage(ERROR, "mitm: TLS handshake with upstream %s failed: %v\n", host, err)
+		return
+	}
+
+	if pinned, ok := mitm.PinnedCertSHA256[hostname]; ok {
+		if err := checkCertPin(serverConn, pinned); err != nil {
+			logMessage(ERROR, "mitm: upstream %s failed cert pin check: %v\n", host, err)
+			return
+		}
+	}
+
+	ctx := &ProxyCtx{ClientConn: tlsClientConn, ServerConn: serverConn, Host: host}
+	relayMitmTraffic(ctx)
+}
+
+// checkCertPin verifies that the leaf certificate presented by conn's
+// peer matches the pinned hex-encoded SHA-256 digest.
+func checkCertPin(conn *tls.Conn, pinnedSHA256 string) error {
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return fmt.Errorf("no peer certificate presented")
+	}
+	sum := sha256.Sum256(certs[0].Raw)
+	if got := hex.EncodeToString(sum[:]); got != pinnedSHA256 {
+		return fmt.Errorf("certificate pin mismatch: got %s, want %s", got, pinnedSHA256)
+	}
+	return nil
+}
+
+// ProxyCtx carries the per-connection state of an intercepted HTTPS
+// session: the live client/server connections plus the request currently
+// being relayed, so that request/response rewriting hooks have somewhere
+// to hang.
+type ProxyCtx struct {
+	ClientConn net.Conn
+	ServerConn net.Conn
+	Host       string
+	Req        *requestRecord
+}
+
+type requestRecord struct {
+	Method string
+	URL    string
+}
+
+// relayMitmTraffic reads one HTTP request at a time from the client with
+// http.ReadRequest, forwards it upstream, and relays the matching
+// response back with http.ReadResponse/.Write - the same framing-aware
+// approach handleHTTP uses, so chunked bodies and persistent connections
+// work instead of desyncing on anything that isn't Content-Length
+// delimited. It returns once either side closes, a request/response
+// fails to parse, or the exchange says not to keep the connection open.
+func relayMitmTraffic(ctx *ProxyCtx) {
+	clientReader := bufio.NewReader(ctx.ClientConn)
+	serverReader := bufio.NewReader(ctx.ServerConn)
+
+	clientIP, _, _ := net.SplitHostPort(ctx.ClientConn.RemoteAddr().String())
+
+	for {
+		start := time.Now()
+		req, err := http.ReadRequest(clientReader)
+		if err != nil {
+			if err != io.EOF {
+				logMessage(ERROR, "mitm: failed to read request from %s: %v\n", ctx.Host, err)
+			}
+			return
+		}
+		ctx.Req = &requestRecord{Method: req.Method, URL: req.URL.String()}
+		logMessage(DEBUG, "mitm: %s %s%s\n", req.Method, ctx.Host, req.URL)
+
+		rec := RequestRecord{Time: start, ClientIP: clientIP, Method: req.Method, Host: ctx.Host, Path: req.URL.Path}
+
+		stripHopByHopHeaders(req.Header)
+		req.RequestURI = ""
+		if req.URL.Host == "" {
+			req.URL.Host = ctx.Host
+		}
+		if req.URL.Scheme == "" {
+			req.URL.Scheme = "https"
+		}
+
+		sentCounter := &countingWriter{Writer: ctx.ServerConn}
+		if err := req.Write(sentCounter); err != nil {
+			logMessage(ERROR, "mitm: failed to forward request to %s: %v\n", ctx.Host, err)
+			rec.Err = err.Error()
+			accessLog.Log(rec)
+			return
+		}
+
+		resp, err := http.ReadResponse(serverReader, req)
+		if err != nil {
+			logMessage(ERROR, "mitm: failed to read response from %s: %v\n", ctx.Host, err)
+			rec.Err = err.Error()
+			accessLog.Log(rec)
+			return
+		}
+		rec.UpstreamLatency = time.Since(start)
+		rec.Status = resp.StatusCode
+		stripHopByHopHeaders(resp.Header)
+
+		recvCounter := &countingWriter{Writer: ctx.ClientConn}
+		err = resp.Write(recvCounter)
+		resp.Body.Close()
+
+		rec.BytesSent = sentCounter.n
+		rec.BytesReceived = recvCounter.n
+		if err != nil {
+			logMessage(ERROR, "mitm: failed to relay response from %s: %v\n", ctx.Host, err)
+			rec.Err = err.Error()
+			accessLog.Log(rec)
+			return
+		}
+		accessLog.Log(rec)
+
+		if req.Close || resp.Close || !req.ProtoAtLeast(1, 1) {
+			return
+		}
+	}
+}
+
+func hostOnly(hostPort string) string {
+	h, _, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return hostPort
+	}
+	return h
+}
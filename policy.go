@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"strings"
+)
+
+// Policy gates which CONNECT ports, hosts, and (when RequireAuth is set)
+// which authenticated clients the proxy will serve.
+type Policy struct {
+	AllowedConnectPorts []int    `json:"allowed_connect_ports"`
+	AllowHosts          []string `json:"allow_hosts"`
+	DenyHosts           []string `json:"deny_hosts"`
+	RequireAuth         bool     `json:"require_auth"`
+	AuthUsername        string   `json:"auth_username"`
+	AuthPassword        string   `json:"auth_password"`
+}
+
+// defaultPolicy is used until a policy file is loaded: CONNECT is limited
+// to the conventional web ports, with no host or auth restrictions.
+var defaultPolicy = &Policy{AllowedConnectPorts: []int{80, 443}}
+
+// policy is the active policy consulted by handleConnection on every
+// request.
+var policy = defaultPolicy
+
+// LoadPolicyFile reads a JSON-encoded Policy from path and installs it as
+// the active policy, keeping the default allowed ports if the file
+// doesn't specify any.
+func LoadPolicyFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	p := &Policy{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return err
+	}
+	if len(p.AllowedConnectPorts) == 0 {
+		p.AllowedConnectPorts = defaultPolicy.AllowedConnectPorts
+	}
+	policy = p
+	return nil
+}
+
+// allowsConnectPort reports whether port may be reached via CONNECT.
+func (p *Policy) allowsConnectPort(port int) bool {
+	if len(p.AllowedConnectPorts) == 0 {
+		return true
+	}
+	for _, allowed := range p.AllowedConnectPorts {
+		if allowed == port {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsHost reports whether host may be proxied to at all: a match in
+// DenyHosts always wins, otherwise an empty AllowHosts list allows
+// everything and a non-empty one acts as an allowlist.
+func (p *Policy) allowsHost(host string) bool {
+	if hostMatchesAny(p.DenyHosts, host) {
+		return false
+	}
+	if len(p.AllowHosts) == 0 {
+		return true
+	}
+	return hostMatchesAny(p.AllowHosts, host)
+}
+
+// checkAuth validates a client-supplied Proxy-Authorization header
+// against the policy's configured Basic credentials. It always succeeds
+// when RequireAuth is false.
+func (p *Policy) checkAuth(header string) bool {
+	if !p.RequireAuth {
+		return true
+	}
+	const prefix = "Basic "
+	if !strings.HasPrefix(header, prefix) {
+		return false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(header, prefix))
+	if err != nil {
+		return false
+	}
+	user, pass, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return false
+	}
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(p.AuthUsername)) == 1
+	passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(p.AuthPassword)) == 1
+	return userOK && passOK
+}
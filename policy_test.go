@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestPolicyAllowsConnectPort(t *testing.T) {
+	p := &Policy{AllowedConnectPorts: []int{80, 443}}
+	if !p.allowsConnectPort(443) {
+		t.Error("expected port 443 to be allowed")
+	}
+	if p.allowsConnectPort(22) {
+		t.Error("expected port 22 to be denied")
+	}
+
+	open := &Policy{}
+	if !open.allowsConnectPort(22) {
+		t.Error("expected an empty AllowedConnectPorts list to allow any port")
+	}
+}
+
+func TestPolicyAllowsHost(t *testing.T) {
+	p := &Policy{
+		AllowHosts: []string{"*.example.com"},
+		DenyHosts:  []string{"blocked.example.com"},
+	}
+	if !p.allowsHost("api.example.com") {
+		t.Error("expected api.example.com to be allowed")
+	}
+	if p.allowsHost("blocked.example.com") {
+		t.Error("expected blocked.example.com to be denied, deny list should win")
+	}
+	if p.allowsHost("other.com") {
+		t.Error("expected other.com to be denied, not in allowlist")
+	}
+
+	open := &Policy{}
+	if !open.allowsHost("anything.com") {
+		t.Error("expected an empty AllowHosts list to allow any host")
+	}
+}
+
+func TestPolicyCheckAuth(t *testing.T) {
+	p := &Policy{RequireAuth: true, AuthUsername: "user", AuthPassword: "pass"}
+
+	if !p.checkAuth(basicAuthHeader("user", "pass")) {
+		t.Error("expected correct credentials to be accepted")
+	}
+	if p.checkAuth(basicAuthHeader("user", "wrong")) {
+		t.Error("expected wrong password to be rejected")
+	}
+	if p.checkAuth(basicAuthHeader("wrong", "pass")) {
+		t.Error("expected wrong username to be rejected")
+	}
+	if p.checkAuth("") {
+		t.Error("expected a missing header to be rejected")
+	}
+	if p.checkAuth("Basic not-base64") {
+		t.Error("expected malformed base64 to be rejected")
+	}
+
+	open := &Policy{RequireAuth: false}
+	if !open.checkAuth("") {
+		t.Error("expected RequireAuth=false to accept any header")
+	}
+}
+
+func basicAuthHeader(user, pass string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+pass))
+}
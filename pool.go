@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+)
+
+// pooledConn is an idle upstream connection kept ready for reuse, paired
+// with the buffered reader that was already reading from it so that any
+// bytes the reader had read ahead aren't lost between requests.
+type pooledConn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	idleSince time.Time
+}
+
+// serverConnPool holds idle upstream connections keyed by "host:port" so
+// keep-alive requests can reuse them instead of dialing a fresh
+// connection per request.
+type serverConnPool struct {
+	mu             sync.Mutex
+	idle           map[string][]*pooledConn
+	maxIdlePerHost int
+	maxIdleTotal   int
+	idleTimeout    time.Duration
+	totalIdle      int
+}
+
+// serverPool is the default pool used for plain HTTP upstream
+// connections.
+var serverPool = newServerConnPool(4, 100, 90*time.Second)
+
+func newServerConnPool(maxIdlePerHost, maxIdleTotal int, idleTimeout time.Duration) *serverConnPool {
+	return &serverConnPool{
+		idle:           make(map[string][]*pooledConn),
+		maxIdlePerHost: maxIdlePerHost,
+		maxIdleTotal:   maxIdleTotal,
+		idleTimeout:    idleTimeout,
+	}
+}
+
+// get pops a still-fresh idle connection for hostPort, discarding any it
+// finds along the way that have exceeded the idle timeout. It returns a
+// nil conn if none is available.
+func (p *serverConnPool) get(hostPort string) (net.Conn, *bufio.Reader) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	conns := p.idle[hostPort]
+	for len(conns) > 0 {
+		pc := conns[len(conns)-1]
+		conns = conns[:len(conns)-1]
+		p.totalIdle--
+
+		if time.Since(pc.idleSince) > p.idleTimeout {
+			pc.conn.Close()
+			continue
+		}
+
+		p.idle[hostPort] = conns
+		return pc.conn, pc.reader
+	}
+	p.idle[hostPort] = conns
+	return nil, nil
+}
+
+// put returns conn/reader to the pool for reuse, closing conn instead if
+// the per-host or total idle limits have already been reached.
+func (p *serverConnPool) put(hostPort string, conn net.Conn, reader *bufio.Reader) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.idle[hostPort]) >= p.maxIdlePerHost || p.totalIdle >= p.maxIdleTotal {
+		conn.Close()
+		return
+	}
+	p.idle[hostPort] = append(p.idle[hostPort], &pooledConn{
+		conn:      conn,
+		reader:    reader,
+		idleSince: time.Now(),
+	})
+	p.totalIdle++
+}